@@ -0,0 +1,356 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dapr/components-contrib/configuration"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgproto3/v2"
+)
+
+const (
+	// cdcLSNTableName stores the last confirmed restart LSN per replication
+	// slot, so that when a CDC attempt is retried after its replication
+	// connection drops, it restarts the existing slot from that position.
+	// Slot names are derived from the subscription id, so a new subscription
+	// (including one made after a process restart) creates a new slot and
+	// does not read the position stored for an earlier subscription.
+	cdcLSNTableName = "dapr_config_cdc_lsn"
+	// standbyStatusUpdateInterval is how often a standby status update is
+	// sent to the server to advance the confirmed flush LSN and keep the
+	// replication connection alive.
+	standbyStatusUpdateInterval = 10 * time.Second
+)
+
+// cdcNames derives the replication slot and publication names for a
+// subscription id. Replication slot names are restricted to [a-z0-9_]+
+// (ReplicationSlotValidateName), and uuid.New() produces hyphens, so both
+// names are derived from a hyphen-free form of the id; pubName is additionally
+// quoted via quotedIdentifier wherever it's used as a SQL identifier.
+func cdcNames(id string) (slotName, pubName string) {
+	idSuffix := strings.ReplaceAll(id, "-", "_")
+	return "dapr_config_" + idSuffix, "dapr_config_pub_" + idSuffix
+}
+
+// doSubscribeCDC streams INSERT/UPDATE/DELETE changes to configTable using
+// logical replication (the pgoutput plugin) instead of LISTEN/NOTIFY. It
+// falls back permanently to the trigger-based NOTIFY path when the driver or
+// server can never support CDC (no cdcCapable driver, wal_level isn't
+// logical), and also when the wal_level check itself fails; later failures (publication/slot setup, a dropped
+// replication connection) are retried with the same backoff used by
+// doSubscribe instead of ending the subscription.
+func (p *ConfigurationStore) doSubscribeCDC(ctx context.Context, req *configuration.SubscribeRequest, handler configuration.UpdateHandler, id string, stop chan struct{}) {
+	cdcDriver, ok := p.client.(cdcCapable)
+	if !ok {
+		p.logger.Warnf("driver %s does not support subscribeMode=cdc, falling back to notify subscribe mode", p.metadata.driver)
+		p.doSubscribe(ctx, req, handler, p.metadata.configTable, id, stop)
+		return
+	}
+
+	supported, err := p.walLevelIsLogical(ctx)
+	if err != nil {
+		p.logger.Errorf("error checking wal_level, falling back to notify subscribe mode: %s", err)
+		p.doSubscribe(ctx, req, handler, p.metadata.configTable, id, stop)
+		return
+	}
+	if !supported {
+		p.logger.Warnf("wal_level is not 'logical', falling back to notify subscribe mode")
+		p.doSubscribe(ctx, req, handler, p.metadata.configTable, id, stop)
+		return
+	}
+
+	attempt := 0
+	backoff := p.metadata.subscribeBackoffInitial
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		err := p.subscribeCDCOnce(ctx, cdcDriver, handler, id, stop)
+		if err == nil {
+			return
+		}
+		if !p.waitSubscribeBackoff(ctx, stop, &attempt, &backoff, err) {
+			p.logger.Errorf("CDC subscription on table %s ended after exhausting retries: %s", p.metadata.configTable, err)
+			return
+		}
+	}
+}
+
+// subscribeCDCOnce sets up the publication and replication slot for a single
+// CDC attempt and streams messages until the connection is lost or stop/ctx
+// fires. A nil error with stop/ctx closed means the caller should return; any
+// other error means the caller should back off and retry.
+func (p *ConfigurationStore) subscribeCDCOnce(ctx context.Context, cdcDriver cdcCapable, handler configuration.UpdateHandler, id string, stop chan struct{}) error {
+	if err := p.ensureCDCLSNTable(ctx); err != nil {
+		return fmt.Errorf("error ensuring CDC restart LSN table: %s", err)
+	}
+
+	slotName, pubName := cdcNames(id)
+
+	pubConn, err := p.client.AcquireConn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection for CDC subscribe: %s", err)
+	}
+	if err := pubConn.Exec(ctx, fmt.Sprintf("CREATE PUBLICATION %s FOR TABLE %s", quotedIdentifier(pubName), quotedIdentifier(p.metadata.configTable))); err != nil {
+		pubConn.Release()
+		return fmt.Errorf("error creating publication %s: %s", pubName, err)
+	}
+	pubConn.Release()
+	defer func() {
+		conn, err := p.client.AcquireConn(context.Background())
+		if err != nil {
+			p.logger.Errorf("error acquiring connection to drop publication %s: %s", pubName, err)
+			return
+		}
+		defer conn.Release()
+		if err := conn.Exec(context.Background(), "DROP PUBLICATION IF EXISTS "+quotedIdentifier(pubName)); err != nil {
+			p.logger.Errorf("error dropping publication %s: %s", pubName, err)
+		}
+	}()
+
+	rawConn, releaseConn, err := cdcDriver.replicationConn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring replication connection for slot %s: %s", slotName, err)
+	}
+	defer releaseConn()
+	pgConn, ok := rawConn.(*pgconn.PgConn)
+	if !ok {
+		return fmt.Errorf("driver %s returned an unexpected replication connection type", p.metadata.driver)
+	}
+
+	startLSN, err := p.loadRestartLSN(ctx, slotName)
+	if err != nil {
+		return fmt.Errorf("error loading restart LSN for slot %s: %s", slotName, err)
+	}
+
+	createOpts := pglogrepl.CreateReplicationSlotOptions{Temporary: false, Mode: pglogrepl.LogicalReplication}
+	if startLSN == 0 {
+		result, err := pglogrepl.CreateReplicationSlot(ctx, pgConn, slotName, "pgoutput", createOpts)
+		if err != nil {
+			return fmt.Errorf("error creating replication slot %s: %s", slotName, err)
+		}
+		startLSN, err = pglogrepl.ParseLSN(result.ConsistentPoint)
+		if err != nil {
+			return fmt.Errorf("error parsing consistent point LSN: %s", err)
+		}
+		// Persist the starting point right away so that a retry reuses this
+		// slot instead of trying to create it a second time.
+		if err := p.saveRestartLSN(ctx, slotName, startLSN); err != nil {
+			return fmt.Errorf("error persisting restart LSN for slot %s: %s", slotName, err)
+		}
+	}
+	p.subscribeSlotMap.Store(id, slotName)
+
+	pluginArgs := []string{"proto_version '1'", fmt.Sprintf("publication_names '%s'", pubName)}
+	if err := pglogrepl.StartReplication(ctx, pgConn, slotName, startLSN, pglogrepl.StartReplicationOptions{PluginArgs: pluginArgs}); err != nil {
+		return fmt.Errorf("error starting replication on slot %s: %s", slotName, err)
+	}
+
+	return p.streamReplicationMessages(ctx, pgConn, handler, id, slotName, startLSN, stop)
+}
+
+// streamReplicationMessages reads WAL messages off the replication
+// connection, translates relevant ones into configuration.UpdateEvents, and
+// periodically reports the confirmed flush LSN back to the server. It
+// returns nil once stop/ctx fires, or a non-nil error if the replication
+// connection was lost, so the caller can distinguish "subscription ended" from
+// "subscription should retry".
+func (p *ConfigurationStore) streamReplicationMessages(ctx context.Context, pgConn *pgconn.PgConn, handler configuration.UpdateHandler, id, slotName string, startLSN pglogrepl.LSN, stop chan struct{}) error {
+	relations := map[uint32]*pglogrepl.RelationMessage{}
+	clientXLogPos := startLSN
+	nextStandbyUpdate := time.Now().Add(standbyStatusUpdateInterval)
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if time.Now().After(nextStandbyUpdate) {
+			if err := pglogrepl.SendStandbyStatusUpdate(ctx, pgConn, pglogrepl.StandbyStatusUpdate{WALWritePosition: clientXLogPos}); err != nil {
+				p.logger.Errorf("error sending standby status update: %s", err)
+			}
+			if err := p.saveRestartLSN(ctx, slotName, clientXLogPos); err != nil {
+				p.logger.Errorf("error persisting restart LSN for slot %s: %s", slotName, err)
+			}
+			nextStandbyUpdate = time.Now().Add(standbyStatusUpdateInterval)
+		}
+
+		recvCtx, cancel := context.WithTimeout(ctx, standbyStatusUpdateInterval)
+		rawMsg, err := pgConn.ReceiveMessage(recvCtx)
+		cancel()
+		if err != nil {
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return fmt.Errorf("error receiving replication message on slot %s: %s", slotName, err)
+		}
+
+		msg, ok := rawMsg.(*pgproto3.CopyData)
+		if !ok {
+			continue
+		}
+
+		switch msg.Data[0] {
+		case pglogrepl.PrimaryKeepaliveMessageByteID:
+			keepalive, err := pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:])
+			if err != nil {
+				p.logger.Errorf("error parsing keepalive message: %s", err)
+				continue
+			}
+			if keepalive.ServerWALEnd > clientXLogPos {
+				clientXLogPos = keepalive.ServerWALEnd
+			}
+		case pglogrepl.XLogDataByteID:
+			xld, err := pglogrepl.ParseXLogData(msg.Data[1:])
+			if err != nil {
+				p.logger.Errorf("error parsing XLogData: %s", err)
+				continue
+			}
+			if xld.WALStart > clientXLogPos {
+				clientXLogPos = xld.WALStart
+			}
+			p.handleWALMessage(ctx, xld.WALData, relations, handler, id)
+		}
+	}
+}
+
+// handleWALMessage decodes a single pgoutput message and, for inserts,
+// updates and deletes against configTable, delivers an UpdateEvent.
+func (p *ConfigurationStore) handleWALMessage(ctx context.Context, data []byte, relations map[uint32]*pglogrepl.RelationMessage, handler configuration.UpdateHandler, id string) {
+	logicalMsg, err := pglogrepl.Parse(data)
+	if err != nil {
+		p.logger.Errorf("error parsing logical replication message: %s", err)
+		return
+	}
+
+	switch m := logicalMsg.(type) {
+	case *pglogrepl.RelationMessage:
+		relations[m.RelationID] = m
+	case *pglogrepl.InsertMessage:
+		p.deliverRowChange(ctx, relations, m.RelationID, m.Tuple, handler, id)
+	case *pglogrepl.UpdateMessage:
+		p.deliverRowChange(ctx, relations, m.RelationID, m.NewTuple, handler, id)
+	case *pglogrepl.DeleteMessage:
+		p.deliverRowChange(ctx, relations, m.RelationID, m.OldTuple, handler, id)
+	}
+}
+
+func (p *ConfigurationStore) deliverRowChange(ctx context.Context, relations map[uint32]*pglogrepl.RelationMessage, relationID uint32, tuple *pglogrepl.TupleData, handler configuration.UpdateHandler, id string) {
+	if tuple == nil {
+		return
+	}
+	rel, ok := relations[relationID]
+	if !ok || rel.RelationName != p.metadata.configTable {
+		return
+	}
+
+	item := configuration.Item{Metadata: map[string]string{}}
+	var key string
+	for i, col := range rel.Columns {
+		if i >= len(tuple.Columns) || tuple.Columns[i].DataType != pglogrepl.TupleDataTypeText {
+			continue
+		}
+		val := string(tuple.Columns[i].Data)
+		switch col.Name {
+		case "key":
+			key = val
+		case "value":
+			item.Value = val
+		case "version":
+			item.Version = val
+		}
+	}
+	if key == "" {
+		return
+	}
+
+	e := &configuration.UpdateEvent{
+		Items: map[string]*configuration.Item{key: &item},
+		ID:    id,
+	}
+	if err := handler(ctx, e); err != nil {
+		p.logger.Errorf("fail to call handler to notify event for configuration update subscribe: %s", err)
+	}
+}
+
+func (p *ConfigurationStore) walLevelIsLogical(ctx context.Context) (bool, error) {
+	var walLevel string
+	if err := p.client.QueryRow(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
+		return false, fmt.Errorf("error checking wal_level: %s", err)
+	}
+	return walLevel == "logical", nil
+}
+
+func (p *ConfigurationStore) ensureCDCLSNTable(ctx context.Context) error {
+	err := p.client.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (slot_name text primary key, restart_lsn text not null, updated_at timestamptz not null default now())`,
+		cdcLSNTableName))
+	if err != nil {
+		return fmt.Errorf("error creating %s table: %s", cdcLSNTableName, err)
+	}
+	return nil
+}
+
+func (p *ConfigurationStore) loadRestartLSN(ctx context.Context, slotName string) (pglogrepl.LSN, error) {
+	var restartLSN string
+	err := p.client.QueryRow(ctx, fmt.Sprintf("SELECT restart_lsn FROM %s WHERE slot_name = $1", cdcLSNTableName), slotName).Scan(&restartLSN)
+	if err != nil {
+		// No persisted position yet: start the slot from its consistent point.
+		return 0, nil
+	}
+	return pglogrepl.ParseLSN(restartLSN)
+}
+
+func (p *ConfigurationStore) saveRestartLSN(ctx context.Context, slotName string, lsn pglogrepl.LSN) error {
+	return p.client.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s (slot_name, restart_lsn, updated_at) VALUES ($1, $2, now())
+		 ON CONFLICT (slot_name) DO UPDATE SET restart_lsn = EXCLUDED.restart_lsn, updated_at = now()`,
+		cdcLSNTableName), slotName, lsn.String())
+}
+
+func (p *ConfigurationStore) dropReplicationSlot(ctx context.Context, slotName string) error {
+	cdcDriver, ok := p.client.(cdcCapable)
+	if !ok {
+		// The subscription never started a CDC slot on this driver.
+		return nil
+	}
+	rawConn, release, err := cdcDriver.replicationConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	pgConn, ok := rawConn.(*pgconn.PgConn)
+	if !ok {
+		return fmt.Errorf("driver %s returned an unexpected replication connection type", p.metadata.driver)
+	}
+	if err := pglogrepl.DropReplicationSlot(ctx, pgConn, slotName, pglogrepl.DropReplicationSlotOptions{}); err != nil {
+		return err
+	}
+	return p.client.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE slot_name = $1", cdcLSNTableName), slotName)
+}
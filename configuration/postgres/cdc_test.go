@@ -0,0 +1,45 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCDCNamesAreHyphenFree(t *testing.T) {
+	slotName, pubName := cdcNames("3fa9c1e2-44d7-4b8a-9c2e-1234567890ab")
+	assert.NotContains(t, slotName, "-")
+	assert.NotContains(t, pubName, "-")
+	assert.Equal(t, "dapr_config_3fa9c1e2_44d7_4b8a_9c2e_1234567890ab", slotName)
+	assert.Equal(t, "dapr_config_pub_3fa9c1e2_44d7_4b8a_9c2e_1234567890ab", pubName)
+}
+
+func TestCDCPublicationNameIsQuotedAsIdentifier(t *testing.T) {
+	_, pubName := cdcNames("3fa9c1e2-44d7-4b8a-9c2e-1234567890ab")
+	assert.Equal(t, `"dapr_config_pub_3fa9c1e2_44d7_4b8a_9c2e_1234567890ab"`, quotedIdentifier(pubName))
+}
+
+// TestReplicationConnRequestsReplicationRuntimeParam exercises the
+// replicationConnConfig step pgx4Driver.replicationConn uses, without opening
+// a real connection, to pin down that the replication connection is built with
+// replication=database rather than reused from the regular query pool (which
+// the server would reject for CREATE_REPLICATION_SLOT/START_REPLICATION).
+func TestReplicationConnRequestsReplicationRuntimeParam(t *testing.T) {
+	connConfig, err := replicationConnConfig("postgres://localhost/configtable")
+	require.NoError(t, err)
+	assert.Equal(t, "database", connConfig.RuntimeParams["replication"])
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"sync"
+	"time"
+)
+
+// deadlineTimer tracks independent read and write deadlines, each backed by
+// a cancel channel that is closed when the deadline expires. Modeled on the
+// deadline-timer pattern used by netstack's gonet adapter: callers select on
+// the relevant cancel channel alongside the operation they're bounding,
+// instead of plumbing a fresh context.WithTimeout through every call.
+type deadlineTimer struct {
+	mu sync.Mutex
+
+	readTimer    *time.Timer
+	readCancelCh chan struct{}
+
+	writeTimer    *time.Timer
+	writeCancelCh chan struct{}
+}
+
+func newDeadlineTimer() *deadlineTimer {
+	return &deadlineTimer{
+		readCancelCh:  make(chan struct{}),
+		writeCancelCh: make(chan struct{}),
+	}
+}
+
+// setReadDeadline arms the read cancel channel to close after timeout. A zero
+// duration disarms it (the channel is replaced but never closed).
+func (d *deadlineTimer) setReadDeadline(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readTimer != nil {
+		d.readTimer.Stop()
+	}
+	d.readCancelCh = make(chan struct{})
+	if timeout <= 0 {
+		d.readTimer = nil
+		return
+	}
+	ch := d.readCancelCh
+	d.readTimer = time.AfterFunc(timeout, func() { close(ch) })
+}
+
+// setWriteDeadline is the write-side equivalent of setReadDeadline.
+func (d *deadlineTimer) setWriteDeadline(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writeTimer != nil {
+		d.writeTimer.Stop()
+	}
+	d.writeCancelCh = make(chan struct{})
+	if timeout <= 0 {
+		d.writeTimer = nil
+		return
+	}
+	ch := d.writeCancelCh
+	d.writeTimer = time.AfterFunc(timeout, func() { close(ch) })
+}
+
+func (d *deadlineTimer) readCancel() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.readCancelCh
+}
+
+func (d *deadlineTimer) writeCancel() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeCancelCh
+}
+
+// stop releases both timers; safe to call more than once.
+func (d *deadlineTimer) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readTimer != nil {
+		d.readTimer.Stop()
+	}
+	if d.writeTimer != nil {
+		d.writeTimer.Stop()
+	}
+}
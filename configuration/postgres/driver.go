@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"context"
+	"time"
+)
+
+// driverType selects the underlying client library used to talk to
+// PostgreSQL. pgx4 is the default for backwards compatibility with
+// existing deployments.
+type driverType string
+
+const (
+	driverPgx4  driverType = "pgx4"
+	driverPgx5  driverType = "pgx5"
+	driverLibpq driverType = "libpq"
+)
+
+// Notification is a single LISTEN/NOTIFY payload delivered on a channel
+// returned by Driver.Listen.
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+// Row is the result of QueryRow: a single, possibly absent, result row.
+type Row interface {
+	Scan(dest ...interface{}) error
+}
+
+// Rows is the result of Query: a cursor over zero or more result rows.
+type Rows interface {
+	Row
+	Next() bool
+	Close()
+	Err() error
+}
+
+// Conn is a single connection acquired from the pool, used for statements
+// that must run on a specific, held connection (e.g. LISTEN or replication
+// setup).
+type Conn interface {
+	Exec(ctx context.Context, query string, args ...interface{}) error
+	Release()
+}
+
+// Driver abstracts the PostgreSQL client library used by ConfigurationStore,
+// so the store can run against jackc/pgx/v4, jackc/pgx/v5 or lib/pq without
+// changing the rest of the package.
+type Driver interface {
+	// Connect establishes the pool/connection using connString. The pgx5 and
+	// lib/pq drivers apply maxIdleTime as the idle connection lifetime;
+	// pgx4Driver does not use it.
+	Connect(ctx context.Context, connString string, maxIdleTime time.Duration) error
+	Ping(ctx context.Context) error
+	Query(ctx context.Context, query string, args ...interface{}) (Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) Row
+	Exec(ctx context.Context, query string, args ...interface{}) error
+	// Listen starts listening on channel and returns a channel of
+	// notifications. The returned channel is closed when ctx is done or
+	// the underlying connection is lost; lib/pq's listener reconnects by
+	// itself, so on libpqDriver a lost connection does not close it.
+	Listen(ctx context.Context, channel string) (<-chan Notification, error)
+	// AcquireConn returns a single connection from the pool for statements
+	// that must run on one held connection (e.g. creating the CDC
+	// publication).
+	AcquireConn(ctx context.Context) (Conn, error)
+	Close()
+}
+
+// cdcCapable is implemented by drivers that can hand out a raw replication
+// connection. Only pgx4Driver implements it; pgx5Driver and libpqDriver do
+// not support logical replication.
+type cdcCapable interface {
+	// replicationConn returns a connection usable for the logical
+	// replication protocol, and a release function that closes it once the
+	// caller is done with it.
+	replicationConn(ctx context.Context) (replicationConn interface{}, release func(), err error)
+}
+
+func newDriver(kind driverType) (Driver, error) {
+	switch kind {
+	case "", driverPgx4:
+		return &pgx4Driver{}, nil
+	case driverPgx5:
+		return &pgx5Driver{}, nil
+	case driverLibpq:
+		return &libpqDriver{}, nil
+	default:
+		return nil, errUnsupportedDriver(kind)
+	}
+}
+
+type errUnsupportedDriver driverType
+
+func (e errUnsupportedDriver) Error() string {
+	return "unsupported postgres configuration store driver: " + string(e)
+}
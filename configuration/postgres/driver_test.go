@@ -0,0 +1,46 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDriverDefaultsToPgx4(t *testing.T) {
+	d, err := newDriver("")
+	require.NoError(t, err)
+	assert.IsType(t, &pgx4Driver{}, d)
+}
+
+func TestNewDriverRejectsUnknownKind(t *testing.T) {
+	_, err := newDriver("mysql")
+	require.Error(t, err)
+}
+
+func TestOnlyPgx4DriverSupportsCDC(t *testing.T) {
+	var pgx4 Driver = &pgx4Driver{}
+	_, ok := pgx4.(cdcCapable)
+	assert.True(t, ok)
+
+	var pgx5 Driver = &pgx5Driver{}
+	_, ok = pgx5.(cdcCapable)
+	assert.False(t, ok)
+
+	var libpq Driver = &libpqDriver{}
+	_, ok = libpq.(cdcCapable)
+	assert.False(t, ok)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// libpqDriver runs the configuration store on top of database/sql and
+// lib/pq, for deployments already standardized on a database/sql stack.
+// It does not support subscribeMode=cdc: lib/pq does not expose the
+// replication protocol.
+type libpqDriver struct {
+	db       *sql.DB
+	listener *pq.Listener
+}
+
+func (d *libpqDriver) Connect(ctx context.Context, connString string, maxIdleTime time.Duration) error {
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return fmt.Errorf("postgres configuration store connection error : %s", err)
+	}
+	db.SetConnMaxIdleTime(maxIdleTime)
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("postgres configuration store ping error : %s", err)
+	}
+	d.db = db
+	d.listener = pq.NewListener(connString, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			// Not surfaced: pq.Listener retries reconnects internally, and
+			// Listen skips the nil notification it sends after a reconnect.
+			return
+		}
+	})
+	return nil
+}
+
+func (d *libpqDriver) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *libpqDriver) Query(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlRows{rows}, nil
+}
+
+func (d *libpqDriver) QueryRow(ctx context.Context, query string, args ...interface{}) Row {
+	return d.db.QueryRowContext(ctx, query, args...)
+}
+
+func (d *libpqDriver) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := d.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (d *libpqDriver) Listen(ctx context.Context, channel string) (<-chan Notification, error) {
+	if err := d.listener.Listen(channel); err != nil {
+		return nil, fmt.Errorf("error listening to channel %s: %s", channel, err)
+	}
+
+	ch := make(chan Notification)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				_ = d.listener.Unlisten(channel)
+				return
+			case n, ok := <-d.listener.Notify:
+				if !ok {
+					return
+				}
+				if n == nil {
+					// nil notification: connection was lost and re-established.
+					continue
+				}
+				select {
+				case ch <- Notification{Channel: n.Channel, Payload: n.Extra}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (d *libpqDriver) AcquireConn(ctx context.Context) (Conn, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &libpqConn{conn: conn}, nil
+}
+
+func (d *libpqDriver) Close() {
+	if d.listener != nil {
+		d.listener.Close()
+	}
+	if d.db != nil {
+		d.db.Close()
+	}
+}
+
+// sqlRows adapts *sql.Rows to the Rows interface: database/sql's Close()
+// returns an error, while Rows.Close() (modeled on pgx.Rows) does not, so
+// any close error is dropped here rather than surfaced.
+type sqlRows struct {
+	*sql.Rows
+}
+
+func (r *sqlRows) Close() {
+	_ = r.Rows.Close()
+}
+
+type libpqConn struct {
+	conn *sql.Conn
+}
+
+func (c *libpqConn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := c.conn.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (c *libpqConn) Release() {
+	c.conn.Close()
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import "time"
+
+// subscribeMode selects the mechanism used by Subscribe to observe changes
+// to the configuration table.
+type subscribeMode string
+
+const (
+	// subscribeModeNotify uses the legacy trigger + LISTEN/NOTIFY mechanism.
+	subscribeModeNotify subscribeMode = "notify"
+	// subscribeModeCDC uses logical replication (pgoutput) to stream WAL changes.
+	subscribeModeCDC subscribeMode = "cdc"
+)
+
+type metadata struct {
+	connectionString string
+	configTable      string
+	maxIdleTime      time.Duration
+	subscribeMode    subscribeMode
+	driver           driverType
+	autoCreate       bool
+	dropOnClose      bool
+
+	// maxSubscribeRetries bounds how many times doSubscribe reconnects
+	// after losing its LISTEN connection before giving up. 0 means retry
+	// indefinitely.
+	maxSubscribeRetries int
+	// subscribeBackoffInitial and subscribeBackoffMax bound the exponential
+	// backoff applied between reconnect attempts.
+	subscribeBackoffInitial time.Duration
+	subscribeBackoffMax     time.Duration
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// pgx4Driver is the default Driver implementation, backed by
+// jackc/pgx/v4/pgxpool. It is kept for backwards compatibility with
+// deployments that pin to pgx/v4.
+type pgx4Driver struct {
+	pool       *pgxpool.Pool
+	connString string
+}
+
+func (d *pgx4Driver) Connect(ctx context.Context, connString string, maxIdleTime time.Duration) error {
+	pool, err := Connect(ctx, connString, maxIdleTime)
+	if err != nil {
+		return err
+	}
+	d.pool = pool
+	d.connString = connString
+	return nil
+}
+
+// Connect opens a pgx/v4 pool and pings it. Exposed for callers that need a
+// raw pool (e.g. tests), in addition to its use by pgx4Driver.
+func Connect(ctx context.Context, conn string, maxIdleTime time.Duration) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.Connect(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres configuration store connection error : %s", err)
+	}
+	pingErr := pool.Ping(ctx)
+	if pingErr != nil {
+		return nil, fmt.Errorf("postgres configuration store ping error : %s", pingErr)
+	}
+	return pool, nil
+}
+
+func (d *pgx4Driver) Ping(ctx context.Context) error {
+	return d.pool.Ping(ctx)
+}
+
+func (d *pgx4Driver) Query(ctx context.Context, query string, args ...interface{}) (Rows, error) {
+	return d.pool.Query(ctx, query, args...)
+}
+
+func (d *pgx4Driver) QueryRow(ctx context.Context, query string, args ...interface{}) Row {
+	return d.pool.QueryRow(ctx, query, args...)
+}
+
+func (d *pgx4Driver) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := d.pool.Exec(ctx, query, args...)
+	return err
+}
+
+func (d *pgx4Driver) Listen(ctx context.Context, channel string) (<-chan Notification, error) {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		conn.Release()
+		return nil, err
+	}
+
+	ch := make(chan Notification)
+	go func() {
+		defer close(ch)
+		defer conn.Release()
+		for {
+			n, err := conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- Notification{Channel: n.Channel, Payload: n.Payload}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (d *pgx4Driver) AcquireConn(ctx context.Context) (Conn, error) {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pgx4Conn{conn: conn}, nil
+}
+
+func (d *pgx4Driver) Close() {
+	d.pool.Close()
+}
+
+// replicationConn implements cdcCapable. The server only accepts
+// CREATE_REPLICATION_SLOT/START_REPLICATION on a connection that negotiated
+// replication mode in its startup packet, so this opens a dedicated
+// *pgconn.PgConn with replication=database rather than handing out a
+// connection acquired from the regular query pool.
+func (d *pgx4Driver) replicationConn(ctx context.Context) (interface{}, func(), error) {
+	connConfig, err := replicationConnConfig(d.connString)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pgConn, err := pgconn.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening replication connection: %s", err)
+	}
+	release := func() {
+		_ = pgConn.Close(context.Background())
+	}
+	return pgConn, release, nil
+}
+
+// replicationConnConfig parses connString and requests replication=database
+// in the startup parameters.
+func replicationConnConfig(connString string) (*pgconn.Config, error) {
+	connConfig, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing connection string for replication connection: %s", err)
+	}
+	connConfig.RuntimeParams["replication"] = "database"
+	return connConfig, nil
+}
+
+type pgx4Conn struct {
+	conn *pgxpool.Conn
+}
+
+func (c *pgx4Conn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := c.conn.Exec(ctx, query, args...)
+	return err
+}
+
+func (c *pgx4Conn) Release() {
+	c.conn.Release()
+}
+
+func (c *pgx4Conn) PgConn() *pgconn.PgConn {
+	return c.conn.Conn().PgConn()
+}
+
+// QueryRow is a small helper retained for callers that already hold a raw
+// pgx/v4 pool (e.g. tests). It runs query with tbl as its argument and scans
+// a single boolean, but returns only query or scan errors: the scanned value
+// is discarded, so a nil error does not mean the table exists.
+func QueryRow(ctx context.Context, p *pgxpool.Pool, query string, tbl string) error {
+	exists := false
+	err := p.QueryRow(ctx, query, tbl).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("postgres configuration store query error : %s", err)
+	}
+	return nil
+}
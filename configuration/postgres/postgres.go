@@ -17,10 +17,9 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,31 +27,49 @@ import (
 	"github.com/dapr/components-contrib/configuration"
 	"github.com/dapr/kit/logger"
 	"github.com/google/uuid"
-	"github.com/jackc/pgconn"
-	"github.com/jackc/pgx/v4/pgxpool"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v4"
 	"golang.org/x/exp/utf8string"
 )
 
 type ConfigurationStore struct {
 	metadata             metadata
-	client               *pgxpool.Pool
+	client               Driver
 	logger               logger.Logger
 	subscribeStopChanMap sync.Map
+	// subscribeSlotMap tracks the logical replication slot (if any) opened
+	// for a given subscription ID, so Unsubscribe can drop it.
+	subscribeSlotMap sync.Map
 }
 
 const (
-	configtablekey               = "table"
-	connMaxIdleTimeKey           = "connMaxIdleTime"
-	connectionStringKey          = "connectionString"
-	ErrorMissingTableName        = "missing postgreSQL configuration table name"
-	InfoStartInit                = "Initializing PostgreSQL state store"
-	ErrorMissingConnectionString = "missing postgreSQL connection string"
-	ErrorAlreadyInitialized      = "PostgreSQL configuration store already initialized"
-	ErrorMissinMaxTimeout        = "missing PostgreSQL maxTimeout setting in configuration"
-	QueryTableExists             = "SELECT EXISTS (SELECT FROM pg_tables where tablename = $1)"
-	maxIdentifierLength          = 64 // https://www.postgresql.org/docs/current/limits.html
-	ErrorTooLongFieldLength      = "field name is too long"
+	configtablekey                 = "table"
+	connMaxIdleTimeKey             = "connMaxIdleTime"
+	connectionStringKey            = "connectionString"
+	subscribeModeKey               = "subscribeMode"
+	driverKey                      = "driver"
+	autoCreateKey                  = "autoCreate"
+	dropOnCloseKey                 = "dropOnClose"
+	maxSubscribeRetriesKey         = "maxSubscribeRetries"
+	subscribeBackoffInitialKey     = "subscribeBackoffInitial"
+	subscribeBackoffMaxKey         = "subscribeBackoffMax"
+	defaultSubscribeBackoffInitial = 1 * time.Second
+	defaultSubscribeBackoffMax     = 30 * time.Second
+	ErrorInvalidDriver             = "invalid driver, must be one of: pgx4, pgx5, libpq"
+	ErrorMissingTableName          = "missing postgreSQL configuration table name"
+	InfoStartInit                  = "Initializing PostgreSQL state store"
+	ErrorMissingConnectionString   = "missing postgreSQL connection string"
+	ErrorAlreadyInitialized        = "PostgreSQL configuration store already initialized"
+	ErrorMissinMaxTimeout          = "missing PostgreSQL maxTimeout setting in configuration"
+	ErrorInvalidSubscribeMode      = "invalid subscribeMode, must be one of: notify, cdc"
+	QueryTableExists               = "SELECT EXISTS (SELECT FROM pg_tables where tablename = $1)"
+	maxIdentifierLength            = 64 // https://www.postgresql.org/docs/current/limits.html
+	ErrorTooLongFieldLength        = "field name is too long"
+	matchTypeKey                   = "matchType"
+	matchTypeExact                 = "exact"
+	matchTypePrefix                = "prefix"
+	matchTypeRegex                 = "regex"
+	limitKey                       = "limit"
+	afterKeyKey                    = "afterKey"
 )
 
 func NewPostgresConfigurationStore(logger logger.Logger) configuration.Store {
@@ -74,13 +91,17 @@ func (p *ConfigurationStore) Init(metadata configuration.Metadata) error {
 		p.metadata = m
 	}
 
+	driver, err := newDriver(p.metadata.driver)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), p.metadata.maxIdleTime)
 	defer cancel()
-	client, err := Connect(ctx, p.metadata.connectionString, p.metadata.maxIdleTime)
-	if err != nil {
+	if err := driver.Connect(ctx, p.metadata.connectionString, p.metadata.maxIdleTime); err != nil {
 		return err
 	}
-	p.client = client
+	p.client = driver
 	pingErr := p.client.Ping(ctx)
 	if pingErr != nil {
 		return pingErr
@@ -92,17 +113,50 @@ func (p *ConfigurationStore) Init(metadata configuration.Metadata) error {
 	if err != nil {
 		return err
 	}
+	if !exists {
+		if !p.metadata.autoCreate {
+			return nil
+		}
+		if err := p.ensureSchema(ctx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (p *ConfigurationStore) Get(ctx context.Context, req *configuration.GetRequest) (*configuration.GetResponse, error) {
-	query, err := buildQuery(req, p.metadata.configTable)
+	query, args, err := buildQuery(req, p.metadata.configTable)
 	if err != nil {
 		p.logger.Error(err)
 		return nil, err
 	}
 
-	rows, err := p.client.Query(ctx, query)
+	dt := newDeadlineTimer()
+	defer dt.stop()
+	dt.setReadDeadline(p.metadata.maxIdleTime)
+
+	queryCtx, cancel := context.WithTimeout(ctx, p.metadata.maxIdleTime)
+	defer cancel()
+
+	type queryResult struct {
+		rows Rows
+		err  error
+	}
+	resultCh := make(chan queryResult, 1)
+	go func() {
+		rows, err := p.client.Query(queryCtx, query, args...)
+		resultCh <- queryResult{rows, err}
+	}()
+
+	var rows Rows
+	select {
+	case <-dt.readCancel():
+		return nil, fmt.Errorf("timed out querying postgres configuration store")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-resultCh:
+		rows, err = res.rows, res.err
+	}
 	if err != nil {
 		// If no rows exist, return an empty response, otherwise return the error.
 		if err == sql.ErrNoRows {
@@ -110,14 +164,16 @@ func (p *ConfigurationStore) Get(ctx context.Context, req *configuration.GetRequ
 		}
 		return nil, err
 	}
-	response := configuration.GetResponse{}
-	for i := 0; rows.Next(); i++ {
+	defer rows.Close()
+
+	response := configuration.GetResponse{Items: map[string]*configuration.Item{}}
+	for rows.Next() {
 		var item configuration.Item
 		var key string
 		var metadata []byte
 		v := make(map[string]string)
 
-		if err := rows.Scan(key, &item.Value, &item.Version, &metadata); err != nil {
+		if err := rows.Scan(&key, &item.Value, &item.Version, &metadata); err != nil {
 			return nil, err
 		}
 		if err := json.Unmarshal(metadata, &v); err != nil {
@@ -126,19 +182,24 @@ func (p *ConfigurationStore) Get(ctx context.Context, req *configuration.GetRequ
 		item.Metadata = v
 		response.Items[key] = &item
 	}
-	return &response, nil
+	return &response, rows.Err()
 }
 
 func (p *ConfigurationStore) Subscribe(ctx context.Context, req *configuration.SubscribeRequest, handler configuration.UpdateHandler) (string, error) {
 	subscribeID := uuid.New().String()
-	key := "listen " + p.metadata.configTable
+	channel := p.metadata.configTable
 	// subscribe to events raised on the configTable
-	if oldStopChan, ok := p.subscribeStopChanMap.Load(key); ok {
+	if oldStopChan, ok := p.subscribeStopChanMap.Load(channel); ok {
 		close(oldStopChan.(chan struct{}))
 	}
 	stop := make(chan struct{})
 	p.subscribeStopChanMap.Store(subscribeID, stop)
-	go p.doSubscribe(ctx, req, handler, key, subscribeID, stop)
+
+	if p.metadata.subscribeMode == subscribeModeCDC {
+		go p.doSubscribeCDC(ctx, req, handler, subscribeID, stop)
+	} else {
+		go p.doSubscribe(ctx, req, handler, channel, subscribeID, stop)
+	}
 	return subscribeID, nil
 }
 
@@ -147,36 +208,114 @@ func (p *ConfigurationStore) Unsubscribe(ctx context.Context, req *configuration
 		p.subscribeStopChanMap.Delete(req.ID)
 		close(oldStopChan.(chan struct{}))
 	}
+	if slotName, ok := p.subscribeSlotMap.LoadAndDelete(req.ID); ok {
+		if err := p.dropReplicationSlot(ctx, slotName.(string)); err != nil {
+			p.logger.Errorf("error dropping replication slot %s: %s", slotName, err)
+		}
+	}
 	return nil
 }
 
+// doSubscribe listens on channel for configuration change notifications and
+// delivers them to handler. If the LISTEN connection is lost (e.g. on a
+// pgconn.Timeout or a connection reset), it reacquires from the pool,
+// re-issues LISTEN and resumes, backing off exponentially between attempts,
+// instead of silently ending the subscription.
 func (p *ConfigurationStore) doSubscribe(ctx context.Context, req *configuration.SubscribeRequest, handler configuration.UpdateHandler, channel string, id string, stop chan struct{}) {
-	conn, err := p.client.Acquire(ctx)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error acquiring connection:", err)
-	}
-	defer conn.Release()
-	ctxTimeout, cancel := context.WithTimeout(ctx, p.metadata.maxIdleTime)
-	defer cancel()
-	_, err = conn.Exec(ctxTimeout, channel)
-	if err != nil {
-		p.logger.Errorf("Error listening to channel:", err)
-		return
-	}
+	dt := newDeadlineTimer()
+	defer dt.stop()
+
+	attempt := 0
+	backoff := p.metadata.subscribeBackoffInitial
 
 	for {
-		notification, err := conn.Conn().WaitForNotification(ctxTimeout)
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		dt.setWriteDeadline(p.metadata.maxIdleTime)
+		notifications, err := p.client.Listen(ctx, channel)
 		if err != nil {
-			if !(pgconn.Timeout(err) || errors.Is(ctxTimeout.Err(), context.Canceled)) {
-				p.logger.Errorf("Error waiting for notification:", err)
+			if !p.waitSubscribeBackoff(ctx, stop, &attempt, &backoff, err) {
+				p.logger.Errorf("error listening to channel %s, giving up: %s", channel, err)
+				return
 			}
+			continue
+		}
+
+		attempt = 0
+		backoff = p.metadata.subscribeBackoffInitial
+
+		lostConnection := p.consumeNotifications(ctx, stop, notifications, handler, id, dt)
+		if !lostConnection {
 			return
 		}
-		p.handleSubscribedChange(ctx, handler, notification, id)
+		if !p.waitSubscribeBackoff(ctx, stop, &attempt, &backoff, fmt.Errorf("notification channel closed unexpectedly")) {
+			p.logger.Errorf("subscription to channel %s ended after exhausting retries", channel)
+			return
+		}
+	}
+}
+
+// consumeNotifications reads notifications until stop/ctx fire (returning
+// false) or the channel closes because the underlying connection was lost
+// (returning true, so the caller retries).
+func (p *ConfigurationStore) consumeNotifications(ctx context.Context, stop chan struct{}, notifications <-chan Notification, handler configuration.UpdateHandler, id string, dt *deadlineTimer) bool {
+	for {
+		dt.setReadDeadline(p.metadata.maxIdleTime)
+		select {
+		case <-stop:
+			return false
+		case <-ctx.Done():
+			return false
+		case <-dt.readCancel():
+			continue
+		case notification, ok := <-notifications:
+			if !ok {
+				return true
+			}
+			p.handleSubscribedChange(ctx, handler, notification, id)
+		}
+	}
+}
+
+// waitSubscribeBackoff sleeps for the current backoff (capped at
+// subscribeBackoffMax, doubling on each call) before the next reconnect
+// attempt. It returns false once maxSubscribeRetries is exhausted (0 means
+// unlimited) or stop/ctx fires during the wait.
+func (p *ConfigurationStore) waitSubscribeBackoff(ctx context.Context, stop chan struct{}, attempt *int, backoff *time.Duration, cause error) bool {
+	if p.metadata.maxSubscribeRetries > 0 && *attempt >= p.metadata.maxSubscribeRetries {
+		return false
 	}
+	*attempt++
+	if *backoff > p.metadata.subscribeBackoffMax {
+		*backoff = p.metadata.subscribeBackoffMax
+	}
+	p.logger.Warnf("subscription error, retrying in %s (attempt %d): %s", *backoff, *attempt, cause)
+
+	timer := time.NewTimer(*backoff)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+
+	next := *backoff * 2
+	if next > p.metadata.subscribeBackoffMax {
+		next = p.metadata.subscribeBackoffMax
+	}
+	*backoff = next
+	return true
 }
 
-func (p *ConfigurationStore) handleSubscribedChange(ctx context.Context, handler configuration.UpdateHandler, msg *pgconn.Notification, id string) {
+func (p *ConfigurationStore) handleSubscribedChange(ctx context.Context, handler configuration.UpdateHandler, msg Notification, id string) {
 	defer func() {
 		if err := recover(); err != nil {
 			p.logger.Errorf("panic in handleSubscribedChange method and recovered: %s", err)
@@ -257,54 +396,147 @@ func parseMetadata(cmetadata configuration.Metadata) (metadata, error) {
 			return m, fmt.Errorf(ErrorMissinMaxTimeout)
 		}
 	}
-	return m, nil
-}
 
-func Connect(ctx context.Context, conn string, maxTimeout time.Duration) (*pgxpool.Pool, error) {
-	pool, err := pgxpool.Connect(ctx, conn)
-	if err != nil {
-		return nil, fmt.Errorf("postgres configuration store connection error : %s", err)
+	// subscribeMode selects between the legacy LISTEN/NOTIFY path and
+	// logical-replication based CDC. Defaults to "notify" for back-compat.
+	m.subscribeMode = subscribeModeNotify
+	if mode, ok := cmetadata.Properties[subscribeModeKey]; ok && mode != "" {
+		switch subscribeMode(mode) {
+		case subscribeModeNotify, subscribeModeCDC:
+			m.subscribeMode = subscribeMode(mode)
+		default:
+			return m, fmt.Errorf(ErrorInvalidSubscribeMode)
+		}
 	}
-	pingErr := pool.Ping(ctx)
-	if pingErr != nil {
-		return nil, fmt.Errorf("postgres configuration store ping error : %s", pingErr)
+
+	// driver selects the underlying client library. Defaults to pgx4 so
+	// existing deployments keep their current behavior.
+	m.driver = driverPgx4
+	if drv, ok := cmetadata.Properties[driverKey]; ok && drv != "" {
+		switch driverType(drv) {
+		case driverPgx4, driverPgx5, driverLibpq:
+			m.driver = driverType(drv)
+		default:
+			return m, fmt.Errorf(ErrorInvalidDriver)
+		}
+	}
+
+	// autoCreate bootstraps the standard schema and NOTIFY trigger when the
+	// configured table does not already exist. dropOnClose additionally
+	// drops that schema when the store is closed; meant for ephemeral or
+	// test deployments.
+	if ac, ok := cmetadata.Properties[autoCreateKey]; ok && ac != "" {
+		autoCreate, err := strconv.ParseBool(ac)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s metadata value %q", autoCreateKey, ac)
+		}
+		m.autoCreate = autoCreate
+	}
+	if doc, ok := cmetadata.Properties[dropOnCloseKey]; ok && doc != "" {
+		dropOnClose, err := strconv.ParseBool(doc)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s metadata value %q", dropOnCloseKey, doc)
+		}
+		m.dropOnClose = dropOnClose
+	}
+
+	// Subscribe retry/backoff: 0 retries means retry indefinitely.
+	if mr, ok := cmetadata.Properties[maxSubscribeRetriesKey]; ok && mr != "" {
+		retries, err := strconv.Atoi(mr)
+		if err != nil || retries < 0 {
+			return m, fmt.Errorf("invalid %s metadata value %q", maxSubscribeRetriesKey, mr)
+		}
+		m.maxSubscribeRetries = retries
+	}
+	m.subscribeBackoffInitial = defaultSubscribeBackoffInitial
+	if bi, ok := cmetadata.Properties[subscribeBackoffInitialKey]; ok && bi != "" {
+		d, err := time.ParseDuration(bi)
+		if err != nil || d <= 0 {
+			return m, fmt.Errorf("invalid %s metadata value %q", subscribeBackoffInitialKey, bi)
+		}
+		m.subscribeBackoffInitial = d
+	}
+	m.subscribeBackoffMax = defaultSubscribeBackoffMax
+	if bm, ok := cmetadata.Properties[subscribeBackoffMaxKey]; ok && bm != "" {
+		d, err := time.ParseDuration(bm)
+		if err != nil || d <= 0 {
+			return m, fmt.Errorf("invalid %s metadata value %q", subscribeBackoffMaxKey, bm)
+		}
+		m.subscribeBackoffMax = d
 	}
-	return pool, nil
+	return m, nil
 }
 
-func buildQuery(req *configuration.GetRequest, configTable string) (string, error) {
-	var query string
-	if len(req.Keys) == 0 {
-		query = "SELECT * FROM " + configTable
-	} else {
-		var queryBuilder strings.Builder
-		queryBuilder.WriteString("SELECT * FROM " + configTable + " WHERE KEY IN ('")
-		queryBuilder.WriteString(strings.Join(req.Keys, "','"))
-		queryBuilder.WriteString("')")
-		query = queryBuilder.String()
-	}
-
-	if len(req.Metadata) > 0 {
-		var s strings.Builder
-		i, j := len(req.Metadata), 0
-		s.WriteString(" AND ")
-		for k, v := range req.Metadata {
-			temp := k + "='" + v + "'"
-			s.WriteString(temp)
-			if j++; j < i {
-				s.WriteString(" AND ")
+// buildQuery translates a configuration.GetRequest into a parameterized SQL
+// query. req.Keys are matched according to the "matchType" request metadata
+// ("exact" (default), "prefix" or "regex"); any remaining metadata entries
+// are applied as additional exact-match column filters. "limit" and
+// "afterKey" request metadata enable keyset pagination: callers walk a large
+// store by re-issuing Get with afterKey set to the last key seen.
+func buildQuery(req *configuration.GetRequest, configTable string) (string, []interface{}, error) {
+	var args []interface{}
+	var conditions []string
+
+	matchType := req.Metadata[matchTypeKey]
+	if matchType == "" {
+		matchType = matchTypeExact
+	}
+
+	if len(req.Keys) > 0 {
+		switch matchType {
+		case matchTypeExact:
+			placeholders := make([]string, len(req.Keys))
+			for i, k := range req.Keys {
+				args = append(args, k)
+				placeholders[i] = fmt.Sprintf("$%d", len(args))
+			}
+			conditions = append(conditions, "key IN ("+strings.Join(placeholders, ",")+")")
+		case matchTypePrefix:
+			var prefixConds []string
+			for _, k := range req.Keys {
+				args = append(args, k)
+				prefixConds = append(prefixConds, fmt.Sprintf("key LIKE $%d || '%%'", len(args)))
 			}
+			conditions = append(conditions, "("+strings.Join(prefixConds, " OR ")+")")
+		case matchTypeRegex:
+			var regexConds []string
+			for _, k := range req.Keys {
+				args = append(args, k)
+				regexConds = append(regexConds, fmt.Sprintf("key ~ $%d", len(args)))
+			}
+			conditions = append(conditions, "("+strings.Join(regexConds, " OR ")+")")
+		default:
+			return "", nil, fmt.Errorf("unsupported matchType %q", matchType)
 		}
-		query += s.String()
 	}
-	return query, nil
-}
 
-func QueryRow(ctx context.Context, p *pgxpool.Pool, query string, tbl string) error {
-	exists := false
-	err := p.QueryRow(ctx, query, tbl).Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("postgres configuration store query error : %s", err)
+	if afterKey, ok := req.Metadata[afterKeyKey]; ok && afterKey != "" {
+		args = append(args, afterKey)
+		conditions = append(conditions, fmt.Sprintf("key > $%d", len(args)))
 	}
-	return nil
+
+	for k, v := range req.Metadata {
+		if k == matchTypeKey || k == afterKeyKey || k == limitKey {
+			continue
+		}
+		args = append(args, v)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", pgx.Identifier{k}.Sanitize(), len(args)))
+	}
+
+	query := "SELECT key, value, version, metadata FROM " + quotedIdentifier(configTable)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY key"
+
+	if limitStr, ok := req.Metadata[limitKey]; ok && limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return "", nil, fmt.Errorf("invalid %s metadata value %q", limitKey, limitStr)
+		}
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	return query, args, nil
 }
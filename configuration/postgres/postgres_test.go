@@ -0,0 +1,173 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dapr/components-contrib/configuration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildQueryExactMatch(t *testing.T) {
+	req := &configuration.GetRequest{Keys: []string{"k1", "k2"}}
+	query, args, err := buildQuery(req, "configtable")
+	require.NoError(t, err)
+	assert.Contains(t, query, "key IN ($1,$2)")
+	assert.Equal(t, []interface{}{"k1", "k2"}, args)
+}
+
+func TestBuildQueryPrefixMatch(t *testing.T) {
+	req := &configuration.GetRequest{
+		Keys:     []string{"app1/"},
+		Metadata: map[string]string{matchTypeKey: matchTypePrefix},
+	}
+	query, args, err := buildQuery(req, "configtable")
+	require.NoError(t, err)
+	assert.Contains(t, query, "key LIKE $1 || '%'")
+	assert.Equal(t, []interface{}{"app1/"}, args)
+}
+
+func TestBuildQueryRegexMatch(t *testing.T) {
+	req := &configuration.GetRequest{
+		Keys:     []string{"^app[0-9]+$"},
+		Metadata: map[string]string{matchTypeKey: matchTypeRegex},
+	}
+	query, args, err := buildQuery(req, "configtable")
+	require.NoError(t, err)
+	assert.Contains(t, query, "key ~ $1")
+	assert.Equal(t, []interface{}{"^app[0-9]+$"}, args)
+}
+
+func TestBuildQueryUnsupportedMatchType(t *testing.T) {
+	req := &configuration.GetRequest{
+		Keys:     []string{"k1"},
+		Metadata: map[string]string{matchTypeKey: "glob"},
+	}
+	_, _, err := buildQuery(req, "configtable")
+	require.Error(t, err)
+}
+
+func TestBuildQueryPrefixWithPagination(t *testing.T) {
+	req := &configuration.GetRequest{
+		Keys: []string{"app1/"},
+		Metadata: map[string]string{
+			matchTypeKey: matchTypePrefix,
+			afterKeyKey:  "app1/k5",
+			limitKey:     "25",
+		},
+	}
+	query, args, err := buildQuery(req, "configtable")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(query, "LIMIT $3"))
+	assert.Contains(t, query, "key > $2")
+	assert.Equal(t, []interface{}{"app1/", "app1/k5", 25}, args)
+}
+
+func TestBuildQueryInvalidLimit(t *testing.T) {
+	req := &configuration.GetRequest{Metadata: map[string]string{limitKey: "not-a-number"}}
+	_, _, err := buildQuery(req, "configtable")
+	require.Error(t, err)
+}
+
+func TestParseMetadataDriverDefaultsToPgx4(t *testing.T) {
+	m, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey: "postgres://localhost",
+		configtablekey:      "configtable",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, driverPgx4, m.driver)
+}
+
+func TestParseMetadataDriverRejectsUnknownValue(t *testing.T) {
+	_, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey: "postgres://localhost",
+		configtablekey:      "configtable",
+		driverKey:           "mysql",
+	}})
+	require.Error(t, err)
+}
+
+func TestParseMetadataAutoCreateAndDropOnClose(t *testing.T) {
+	m, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey: "postgres://localhost",
+		configtablekey:      "configtable",
+		autoCreateKey:       "true",
+		dropOnCloseKey:      "true",
+	}})
+	require.NoError(t, err)
+	assert.True(t, m.autoCreate)
+	assert.True(t, m.dropOnClose)
+}
+
+func TestParseMetadataAutoCreateRejectsNonBool(t *testing.T) {
+	_, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey: "postgres://localhost",
+		configtablekey:      "configtable",
+		autoCreateKey:       "yes-please",
+	}})
+	require.Error(t, err)
+}
+
+func TestParseMetadataSubscribeBackoffDefaults(t *testing.T) {
+	m, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey: "postgres://localhost",
+		configtablekey:      "configtable",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.maxSubscribeRetries)
+	assert.Equal(t, defaultSubscribeBackoffInitial, m.subscribeBackoffInitial)
+	assert.Equal(t, defaultSubscribeBackoffMax, m.subscribeBackoffMax)
+}
+
+func TestParseMetadataSubscribeBackoffOverrides(t *testing.T) {
+	m, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey:        "postgres://localhost",
+		configtablekey:             "configtable",
+		maxSubscribeRetriesKey:     "5",
+		subscribeBackoffInitialKey: "2s",
+		subscribeBackoffMaxKey:     "1m",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, m.maxSubscribeRetries)
+	assert.Equal(t, 2*time.Second, m.subscribeBackoffInitial)
+	assert.Equal(t, time.Minute, m.subscribeBackoffMax)
+}
+
+func TestParseMetadataMaxSubscribeRetriesRejectsNegative(t *testing.T) {
+	_, err := parseMetadata(configuration.Metadata{Properties: map[string]string{
+		connectionStringKey:    "postgres://localhost",
+		configtablekey:         "configtable",
+		maxSubscribeRetriesKey: "-1",
+	}})
+	require.Error(t, err)
+}
+
+func TestQuotedIdentifierSanitizesInjectionAttempt(t *testing.T) {
+	quoted := quotedIdentifier(`config"; DROP TABLE users; --`)
+	assert.Equal(t, `"config""; DROP TABLE users; --"`, quoted)
+}
+
+func TestBuildQueryMetadataValuesAreParameterized(t *testing.T) {
+	req := &configuration.GetRequest{
+		Metadata: map[string]string{"env": "prod'; DROP TABLE configtable; --"},
+	}
+	query, args, err := buildQuery(req, "configtable")
+	require.NoError(t, err)
+	assert.NotContains(t, query, "DROP TABLE")
+	assert.Equal(t, []interface{}{"prod'; DROP TABLE configtable; --"}, args)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// quotedIdentifier safely quotes a single SQL identifier (table, function or
+// trigger name) so it can be interpolated into a query string.
+func quotedIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// notifyFunctionName and notifyTriggerName are derived from configTable so
+// that multiple ConfigurationStores pointed at different tables in the same
+// database don't collide.
+func notifyFunctionName(configTable string) string {
+	return configTable + "_notify"
+}
+
+func notifyTriggerName(configTable string) string {
+	return configTable + "_notify_trigger"
+}
+
+// ensureSchema creates the standard configuration schema, its NOTIFY
+// trigger function and the trigger itself, when autoCreate is enabled and
+// the table does not already exist. Subsequent Subscribe calls in the
+// default "notify" mode rely on this trigger to populate the payload that
+// handleSubscribedChange expects (a top-level "data" object).
+func (p *ConfigurationStore) ensureSchema(ctx context.Context) error {
+	table := quotedIdentifier(p.metadata.configTable)
+	fn := quotedIdentifier(notifyFunctionName(p.metadata.configTable))
+	trigger := quotedIdentifier(notifyTriggerName(p.metadata.configTable))
+
+	if err := p.client.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE %s (key text primary key, value text, version text, metadata jsonb)`, table)); err != nil {
+		return fmt.Errorf("error creating table %s: %s", table, err)
+	}
+
+	// configTable is embedded in a single-quoted string literal (the NOTIFY
+	// channel name), not as a SQL identifier, so it needs literal-escaping
+	// rather than quotedIdentifier here.
+	channel := strings.ReplaceAll(p.metadata.configTable, "'", "''")
+	if err := p.client.Exec(ctx, fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+		BEGIN
+			PERFORM pg_notify('%s', json_build_object('data', row_to_json(COALESCE(NEW, OLD)))::text);
+			RETURN COALESCE(NEW, OLD);
+		END;
+		$$ LANGUAGE plpgsql`, fn, channel)); err != nil {
+		return fmt.Errorf("error creating notify function %s: %s", fn, err)
+	}
+
+	if err := p.client.Exec(ctx, fmt.Sprintf(
+		`CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s()`,
+		trigger, table, fn)); err != nil {
+		return fmt.Errorf("error creating notify trigger %s: %s", trigger, err)
+	}
+
+	return nil
+}
+
+// dropSchema removes the table and its notify trigger/function, for
+// dropOnClose=true deployments (ephemeral or test-only stores).
+func (p *ConfigurationStore) dropSchema(ctx context.Context) error {
+	table := quotedIdentifier(p.metadata.configTable)
+	fn := quotedIdentifier(notifyFunctionName(p.metadata.configTable))
+
+	if err := p.client.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table)); err != nil {
+		return fmt.Errorf("error dropping table %s: %s", table, err)
+	}
+	if err := p.client.Exec(ctx, fmt.Sprintf("DROP FUNCTION IF EXISTS %s() CASCADE", fn)); err != nil {
+		return fmt.Errorf("error dropping notify function %s: %s", fn, err)
+	}
+	return nil
+}
+
+// Close releases the underlying driver connection(s). When dropOnClose is
+// set, the configuration table and its notify trigger are dropped first;
+// this is meant for ephemeral or test deployments.
+func (p *ConfigurationStore) Close() error {
+	if p.client == nil {
+		return nil
+	}
+	if p.metadata.dropOnClose {
+		if err := p.dropSchema(context.Background()); err != nil {
+			p.logger.Errorf("error dropping schema on close: %s", err)
+		}
+	}
+	p.client.Close()
+	return nil
+}
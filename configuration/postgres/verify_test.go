@@ -0,0 +1,42 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestQueriesCoalesceNullableColumns(t *testing.T) {
+	for _, query := range []string{
+		tableDigestQuery("configtable"),
+		bucketDigestsQuery("configtable"),
+		rowDigestsQuery("configtable"),
+	} {
+		assert.Contains(t, query, "COALESCE(value, '')")
+		assert.Contains(t, query, "COALESCE(version, '')")
+		assert.Contains(t, query, "COALESCE(metadata::text, '')")
+	}
+}
+
+func TestDigestExprSeparatesFieldsSoDifferentRowsDontCollide(t *testing.T) {
+	assert.Equal(t, 3, strings.Count(digestExpr, "chr(1)"))
+}
+
+func TestDigestQueriesQuoteTableIdentifier(t *testing.T) {
+	query := tableDigestQuery(`config"; DROP TABLE users; --`)
+	assert.Contains(t, query, `FROM "config""; DROP TABLE users; --"`)
+}